@@ -0,0 +1,41 @@
+package main
+
+import "context"
+
+// Enricher annotates a Feature with reverse-geocoded metadata in place.
+type Enricher interface {
+	Enrich(feature *Feature)
+}
+
+// enrichStage sits between the worker pool and the writer: it reads
+// Features off in, runs them through enricher, and forwards them. When
+// enricher is nil (no --geonames configured; --mmdb alone yields no
+// Enricher) it's a plain pass-through
+// with no extra goroutine.
+func enrichStage(ctx context.Context, enricher Enricher, in <-chan *Feature) <-chan *Feature {
+	if enricher == nil {
+		return in
+	}
+
+	out := make(chan *Feature, cap(in))
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case feature, ok := <-in:
+				if !ok {
+					return
+				}
+				enricher.Enrich(feature)
+				select {
+				case out <- feature:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
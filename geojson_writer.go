@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// geoJSONWriter streams a FeatureCollection to stdout or a file, one Feature
+// at a time, so a large batch never has to be held in memory as one document.
+type geoJSONWriter struct {
+	out   io.Writer
+	file  *os.File
+	wrote bool
+}
+
+func newGeoJSONWriter(path string) (*geoJSONWriter, error) {
+	w := &geoJSONWriter{out: os.Stdout}
+
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w.file = file
+		w.out = file
+	}
+
+	if _, err := io.WriteString(w.out, `{"type":"FeatureCollection","features":[`); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *geoJSONWriter) WriteFeature(feature *Feature) error {
+	if w.wrote {
+		if _, err := io.WriteString(w.out, ","); err != nil {
+			return err
+		}
+	}
+	w.wrote = true
+
+	encoded, err := json.Marshal(featureToGeoJSON(feature))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.out.Write(encoded)
+	return err
+}
+
+// WriteSource is a no-op: a GeoJSON FeatureCollection has nowhere to record
+// per-source bookkeeping, unlike the SQL-backed formats' "sources" table.
+func (w *geoJSONWriter) WriteSource(source *SourceInfo) error {
+	return nil
+}
+
+func (w *geoJSONWriter) Close() error {
+	if _, err := io.WriteString(w.out, "]}"); err != nil {
+		return err
+	}
+	if w.file != nil {
+		return w.file.Close()
+	}
+	return nil
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func featureToGeoJSON(feature *Feature) geoJSONFeature {
+	return geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geometryToGeoJSON(feature),
+		Properties: map[string]interface{}{
+			"nome":           feature.Name,
+			"descricao":      feature.Description,
+			"pais":           feature.CountryCode,
+			"admin1":         feature.Admin1,
+			"cidade_proxima": feature.NearestCity,
+		},
+	}
+}
+
+// geometryToGeoJSON shapes a Feature's coordinates the way the GeoJSON spec
+// nests them per geometry type: a bare pair for Point, a list of pairs for
+// LineString, and a list of rings (we only ever have the outer one) for
+// Polygon.
+func geometryToGeoJSON(feature *Feature) geoJSONGeometry {
+	switch feature.Geometry {
+	case GeometryLineString:
+		return geoJSONGeometry{Type: "LineString", Coordinates: coordinatePairs(feature.Coordinates)}
+	case GeometryPolygon:
+		return geoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{coordinatePairs(feature.Coordinates)}}
+	default:
+		var pair [2]float64
+		if len(feature.Coordinates) > 0 {
+			pair = [2]float64{feature.Coordinates[0].Longitude, feature.Coordinates[0].Latitude}
+		}
+		return geoJSONGeometry{Type: "Point", Coordinates: pair}
+	}
+}
+
+func coordinatePairs(coordinates []Coordinate) [][2]float64 {
+	pairs := make([][2]float64, len(coordinates))
+	for i, c := range coordinates {
+		pairs[i] = [2]float64{c.Longitude, c.Latitude}
+	}
+	return pairs
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// geoLiteFilenamePattern matches the versioned filenames MaxMind publishes,
+// e.g. "GeoLite2-City_20240109.mmdb".
+var geoLiteFilenamePattern = regexp.MustCompile(`GeoLite2-City_(\d{8})\.mmdb`)
+
+// newMMDBEnricher validates that --mmdb points at a real file (so
+// --geolite-autoupdate has something to maintain) but never returns an
+// Enricher: a GeoLite2-City .mmdb database is keyed by IP address ranges,
+// not by latitude/longitude, so it has no way to answer "what's near this
+// coordinate". It logs that fact instead of failing the run, since a user
+// who only wants --geolite-autoupdate to keep the file current shouldn't be
+// blocked from running mercator. Reverse geocoding needs --geonames.
+func newMMDBEnricher(path string) (Enricher, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	log.Printf("--mmdb %s: bancos GeoLite2-City são indexados por IP, não por lat/lon; nenhuma anotação será feita a partir dele, use --geonames para geocodificação reversa de coordenadas", path)
+	return nil, nil
+}
+
+// updateGeoLite checks indexURL for a newer GeoLite2-City_YYYYMMDD.mmdb than
+// the one at dbPath, and if found downloads it, verifies its checksum, and
+// atomically replaces dbPath. The current version is read from dbPath's own
+// filename only: it is a no-op when that filename carries a YYYYMMDD version
+// at least as new as the latest listed, and otherwise downloads. The new file
+// takes dbPath's name, so the version in that name is not advanced.
+func updateGeoLite(dbPath, indexURL string) error {
+	filename, latest, err := latestGeoLiteVersion(indexURL)
+	if err != nil {
+		return err
+	}
+
+	if current, ok := geoLiteVersionOf(dbPath); ok && !latest.After(current) {
+		return nil
+	}
+
+	downloadURL := strings.TrimRight(indexURL, "/") + "/" + filename
+	data, err := downloadWithChecksum(downloadURL)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), "geolite-*.mmdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dbPath)
+}
+
+// geoLiteVersionOf extracts the YYYYMMDD version embedded in a GeoLite2-City
+// filename, if present.
+func geoLiteVersionOf(path string) (time.Time, bool) {
+	match := geoLiteFilenamePattern.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return time.Time{}, false
+	}
+	version, err := time.Parse("20060102", match[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return version, true
+}
+
+// latestGeoLiteVersion fetches indexURL (expected to list the available
+// GeoLite2-City_YYYYMMDD.mmdb filenames) and returns the newest one.
+func latestGeoLiteVersion(indexURL string) (filename string, version time.Time, err error) {
+	body, err := httpGet(indexURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	matches := geoLiteFilenamePattern.FindAllStringSubmatch(string(body), -1)
+	if len(matches) == 0 {
+		return "", time.Time{}, fmt.Errorf("nenhum GeoLite2-City_YYYYMMDD.mmdb encontrado em %s", indexURL)
+	}
+
+	var best string
+	var bestVersion time.Time
+	for _, match := range matches {
+		candidate, err := time.Parse("20060102", match[1])
+		if err != nil {
+			continue
+		}
+		if best == "" || candidate.After(bestVersion) {
+			best = match[0]
+			bestVersion = candidate
+		}
+	}
+	if best == "" {
+		return "", time.Time{}, fmt.Errorf("nenhuma versão válida encontrada em %s", indexURL)
+	}
+
+	return best, bestVersion, nil
+}
+
+// downloadWithChecksum fetches url and verifies it against the SHA-256
+// published at url+".sha256" (MaxMind's own convention), returning the
+// verified bytes.
+func downloadWithChecksum(url string) ([]byte, error) {
+	data, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+
+	checksumLine, err := httpGet(url + ".sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	want := strings.Fields(string(checksumLine))
+	if len(want) == 0 {
+		return nil, fmt.Errorf("checksum vazio para %s", url)
+	}
+
+	got := sha256.Sum256(data)
+	if hex.EncodeToString(got[:]) != want[0] {
+		return nil, fmt.Errorf("checksum não confere para %s", url)
+	}
+
+	return data, nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// geonamesCity is the subset of a GeoNames cities TSV row (as shipped in
+// cities500.txt/cities5000.txt) this tool needs for reverse geocoding.
+type geonamesCity struct {
+	Name        string
+	CountryCode string
+	Admin1      string
+	Latitude    float64
+	Longitude   float64
+}
+
+// geonamesEnricher reverse-geocodes a Feature against an in-memory GeoNames
+// city list by brute-force nearest neighbour, which is fine at GeoNames'
+// city-level scale (tens of thousands of rows, not the full gazetteer).
+type geonamesEnricher struct {
+	cities []geonamesCity
+}
+
+// newGeoNamesEnricher loads a GeoNames cities TSV (geonameid, name,
+// asciiname, alternatenames, latitude, longitude, feature class, feature
+// code, country code, cc2, admin1 code, ...) into memory.
+func newGeoNamesEnricher(path string) (*geonamesEnricher, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cities []geonamesCity
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 11 {
+			continue
+		}
+
+		latitude, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			continue
+		}
+		longitude, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			continue
+		}
+
+		cities = append(cities, geonamesCity{
+			Name:        fields[1],
+			CountryCode: fields[8],
+			Admin1:      fields[10],
+			Latitude:    latitude,
+			Longitude:   longitude,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cities) == 0 {
+		return nil, fmt.Errorf("nenhuma cidade carregada de %s", path)
+	}
+
+	return &geonamesEnricher{cities: cities}, nil
+}
+
+// Enrich tags the feature with the country, admin1 region and name of its
+// nearest GeoNames city, using the feature's first coordinate as the
+// reference point.
+func (e *geonamesEnricher) Enrich(feature *Feature) {
+	if len(feature.Coordinates) == 0 {
+		return
+	}
+
+	nearest := e.nearestCity(feature.Coordinates[0])
+	feature.NearestCity = nearest.Name
+	feature.CountryCode = nearest.CountryCode
+	feature.Admin1 = nearest.Admin1
+}
+
+func (e *geonamesEnricher) nearestCity(point Coordinate) geonamesCity {
+	best := e.cities[0]
+	bestDistance := haversineKm(point, Coordinate{Longitude: best.Longitude, Latitude: best.Latitude})
+
+	for _, city := range e.cities[1:] {
+		distance := haversineKm(point, Coordinate{Longitude: city.Longitude, Latitude: city.Latitude})
+		if distance < bestDistance {
+			best = city
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// earthRadiusKm is the mean Earth radius used by the haversine formula.
+const earthRadiusKm = 6371.0
+
+// haversineKm is the great-circle distance between two lon/lat points, in
+// kilometres.
+func haversineKm(a, b Coordinate) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(b.Latitude - a.Latitude)
+	dLon := toRadians(b.Longitude - a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(a.Latitude))*math.Cos(toRadians(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// wgs84SRID is the SRID registered for every geometry this tool writes;
+// KML coordinates are always lon/lat in WGS 84.
+const wgs84SRID = 4326
+
+// geoPackageWriter writes Features into a GeoPackage (a SQLite file with the
+// OGC gpkg_* bookkeeping tables) so the output loads directly in QGIS/ArcGIS
+// without a manual import step.
+type geoPackageWriter struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	pending int
+}
+
+func newGeoPackageWriter(path string) (*geoPackageWriter, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createGeoPackageTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &geoPackageWriter{db: db, tx: tx}, nil
+}
+
+func createGeoPackageTables(db *sql.DB) error {
+	statements := []string{
+		// 1196444487 is 0x47504B47, the 'GPKG' magic the OGC spec requires in
+		// application_id; without it strict consumers like ArcGIS won't
+		// recognize the file as a GeoPackage. 10300 marks GeoPackage 1.3.
+		`pragma application_id = 1196444487;`,
+		`pragma user_version = 10300;`,
+		`create table if not exists gpkg_spatial_ref_sys(
+			srs_name text not null,
+			srs_id integer not null primary key,
+			organization text not null,
+			organization_coordsys_id integer not null,
+			definition text not null,
+			description text
+		);`,
+		`create table if not exists gpkg_contents(
+			table_name text not null primary key,
+			data_type text not null,
+			identifier text unique,
+			description text default '',
+			last_change text not null,
+			min_x real,
+			min_y real,
+			max_x real,
+			max_y real,
+			srs_id integer
+		);`,
+		`create table if not exists gpkg_geometry_columns(
+			table_name text not null,
+			column_name text not null,
+			geometry_type_name text not null,
+			srs_id integer not null,
+			z tinyint not null,
+			m tinyint not null,
+			primary key (table_name, column_name)
+		);`,
+		`create table if not exists pontos(
+			id text not null primary key,
+			nome text,
+			descricao text,
+			tipo_geometria text,
+			geom blob,
+			pais text,
+			admin1 text,
+			cidade_proxima text
+		);`,
+		`create table if not exists sources(
+			path text not null primary key,
+			sha1 text not null,
+			mtime integer not null
+		);`,
+		`insert into gpkg_spatial_ref_sys(srs_name, srs_id, organization, organization_coordsys_id, definition, description)
+			values ('WGS 84', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]]', 'longitude/latitude WGS 84')
+			on conflict(srs_id) do nothing;`,
+		`insert into gpkg_geometry_columns(table_name, column_name, geometry_type_name, srs_id, z, m)
+			values ('pontos', 'geom', 'GEOMETRY', 4326, 0, 0)
+			on conflict(table_name, column_name) do nothing;`,
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Exec(
+		`insert into gpkg_contents(table_name, data_type, identifier, last_change, srs_id) values (?, ?, ?, ?, ?)
+			on conflict(table_name) do update set last_change = excluded.last_change`,
+		"pontos", "features", "pontos", time.Now().UTC().Format(time.RFC3339), wgs84SRID,
+	)
+	return err
+}
+
+func (w *geoPackageWriter) WriteFeature(feature *Feature) error {
+	if err := featureToGeoPackage(feature, w.tx); err != nil {
+		w.rollback()
+		return err
+	}
+
+	w.pending++
+	if w.pending >= batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *geoPackageWriter) commit() error {
+	if w.pending == 0 {
+		return nil
+	}
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	w.pending = 0
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	w.tx = tx
+	return nil
+}
+
+// rollback abandons the current transaction after a failed write and opens a
+// fresh one in its place, so pending rows already lost to the rollback don't
+// linger in w.pending and a later Close doesn't try to commit a dead tx.
+func (w *geoPackageWriter) rollback() {
+	w.tx.Rollback()
+	w.pending = 0
+
+	if tx, err := w.db.Begin(); err == nil {
+		w.tx = tx
+	}
+}
+
+func (w *geoPackageWriter) WriteSource(source *SourceInfo) error {
+	if err := sourceToSQLite(source, w.tx); err != nil {
+		w.rollback()
+		return err
+	}
+
+	w.pending++
+	if w.pending >= batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *geoPackageWriter) Close() error {
+	if err := w.commit(); err != nil {
+		w.db.Close()
+		return err
+	}
+	return w.db.Close()
+}
+
+func featureToGeoPackage(feature *Feature, db sqlExecutor) error {
+	insertPoint := `
+		insert into pontos(id, nome, descricao, tipo_geometria, geom, pais, admin1, cidade_proxima)
+		values (?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict(id) do update set
+			nome = excluded.nome,
+			descricao = excluded.descricao,
+			tipo_geometria = excluded.tipo_geometria,
+			geom = excluded.geom,
+			pais = excluded.pais,
+			admin1 = excluded.admin1,
+			cidade_proxima = excluded.cidade_proxima
+	`
+	statement, err := db.Prepare(insertPoint)
+	if err != nil {
+		return err
+	}
+
+	_, err = statement.Exec(
+		featureID(feature),
+		feature.Name,
+		feature.Description,
+		string(feature.Geometry),
+		featureToGeoPackageBlob(feature),
+		feature.CountryCode,
+		feature.Admin1,
+		feature.NearestCity,
+	)
+	return err
+}
+
+// featureToGeoPackageBlob encodes a Feature as a GeoPackage binary geometry:
+// the standard GP header (magic, version, flags, SRID) followed by the
+// feature's geometry in WKB.
+func featureToGeoPackageBlob(feature *Feature) []byte {
+	blob := gpkgHeader(wgs84SRID)
+	blob = append(blob, wkbGeometry(feature)...)
+	return blob
+}
+
+func gpkgHeader(srid int32) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('G')
+	buf.WriteByte('P')
+	buf.WriteByte(0)    // version
+	buf.WriteByte(0x01) // flags: little endian, no envelope, not empty
+	binary.Write(buf, binary.LittleEndian, srid)
+	return buf.Bytes()
+}
+
+// wkbGeometry encodes a Feature's coordinates as Well-Known Binary. Polygon
+// features are written with their outer ring only, matching what Feature
+// itself carries.
+func wkbGeometry(feature *Feature) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(1) // little endian
+
+	switch feature.Geometry {
+	case GeometryLineString:
+		binary.Write(buf, binary.LittleEndian, uint32(2))
+		binary.Write(buf, binary.LittleEndian, uint32(len(feature.Coordinates)))
+		writeWkbPoints(buf, feature.Coordinates)
+	case GeometryPolygon:
+		binary.Write(buf, binary.LittleEndian, uint32(3))
+		binary.Write(buf, binary.LittleEndian, uint32(1)) // one ring: the outer boundary
+		binary.Write(buf, binary.LittleEndian, uint32(len(feature.Coordinates)))
+		writeWkbPoints(buf, feature.Coordinates)
+	default: // GeometryPoint
+		binary.Write(buf, binary.LittleEndian, uint32(1))
+		var c Coordinate
+		if len(feature.Coordinates) > 0 {
+			c = feature.Coordinates[0]
+		}
+		binary.Write(buf, binary.LittleEndian, c.Longitude)
+		binary.Write(buf, binary.LittleEndian, c.Latitude)
+	}
+
+	return buf.Bytes()
+}
+
+func writeWkbPoints(buf *bytes.Buffer, coordinates []Coordinate) {
+	for _, c := range coordinates {
+		binary.Write(buf, binary.LittleEndian, c.Longitude)
+		binary.Write(buf, binary.LittleEndian, c.Latitude)
+	}
+}
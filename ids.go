@@ -0,0 +1,16 @@
+package main
+
+import "github.com/google/uuid"
+
+// featureIDNamespace namespaces every feature ID this tool derives, so they
+// can never collide with UUIDs minted by an unrelated system.
+var featureIDNamespace = uuid.MustParse("a61d1df0-6aaf-4a1e-9bda-2c6c6f5c9f1a")
+
+// featureID derives a stable id from a feature's identity (its source KMZ,
+// placemark name, and coordinates) rather than randomly generating one, so
+// re-running mercator on the same corpus is idempotent: the same Placemark
+// always maps to the same row.
+func featureID(feature *Feature) string {
+	name := feature.SourcePath + "|" + feature.Name + "|" + serializeCoordinates(feature.Coordinates)
+	return uuid.NewSHA1(featureIDNamespace, []byte(name)).String()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GeometryType discriminates the kind of geometry a Feature carries.
+type GeometryType string
+
+const (
+	GeometryPoint      GeometryType = "Point"
+	GeometryLineString GeometryType = "LineString"
+	GeometryPolygon    GeometryType = "Polygon"
+)
+
+// Coordinate is a single lon/lat pair as found in a KML <coordinates> element.
+type Coordinate struct {
+	Longitude float64
+	Latitude  float64
+}
+
+// Feature is one Placemark decoded out of a KML document, generalised over
+// the Point/LineString/Polygon geometries KMZ producers emit in practice.
+type Feature struct {
+	SourcePath  string
+	Name        string
+	Description string
+	Geometry    GeometryType
+	Coordinates []Coordinate
+
+	// CountryCode, Admin1 and NearestCity are filled in by an optional
+	// reverse-geocoding Enricher; they stay empty when none is configured.
+	CountryCode string
+	Admin1      string
+	NearestCity string
+}
+
+// SourceInfo records enough about a KMZ to let a later run detect that it
+// changed or disappeared between ingestions.
+type SourceInfo struct {
+	Path    string
+	SHA1    string
+	ModTime time.Time
+}
+
+// computeSourceInfo stats and hashes a KMZ so re-ingesting the same corpus
+// can tell which sources actually changed.
+func computeSourceInfo(path string) (*SourceInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return &SourceInfo{
+		Path:    path,
+		SHA1:    hex.EncodeToString(hash.Sum(nil)),
+		ModTime: stat.ModTime(),
+	}, nil
+}
+
+// kmlFile mirrors the subset of the KML schema we care about: a Document
+// holding Placemarks directly and/or nested inside arbitrarily deep Folders.
+type kmlFile struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Folders    []kmlFolder    `xml:"Folder"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlFolder struct {
+	Folders    []kmlFolder    `xml:"Folder"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description"`
+	Point       *kmlPoint      `xml:"Point"`
+	LineString  *kmlLineString `xml:"LineString"`
+	Polygon     *kmlPolygon    `xml:"Polygon"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlLineString struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPolygon struct {
+	OuterBoundaryIs struct {
+		LinearRing struct {
+			Coordinates string `xml:"coordinates"`
+		} `xml:"LinearRing"`
+	} `xml:"outerBoundaryIs"`
+}
+
+func crawlFileSystem(initialPath string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(
+		initialPath,
+		func(pathSlice *[]string) filepath.WalkFunc {
+			return func(path string, info fs.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if strings.HasSuffix(path, ".kmz") {
+					*pathSlice = append(*pathSlice, path)
+				}
+
+				return nil
+			}
+		}(&paths),
+	)
+	return paths, err
+}
+
+// collectPlacemarks flattens the Folder tree of a KML Document into a single
+// list of Placemarks, since Placemarks can live directly under the Document
+// or nested arbitrarily deep inside Folders.
+func collectPlacemarks(folders []kmlFolder, placemarks []kmlPlacemark) []kmlPlacemark {
+	all := append([]kmlPlacemark{}, placemarks...)
+	for _, folder := range folders {
+		all = append(all, collectPlacemarks(folder.Folders, folder.Placemarks)...)
+	}
+	return all
+}
+
+// parseCoordinates turns a KML <coordinates> element ("lon,lat,alt lon,lat,alt ...")
+// into a slice of Coordinate, ignoring the optional altitude component.
+func parseCoordinates(raw string) []Coordinate {
+	var coordinates []Coordinate
+	for _, tuple := range strings.Fields(raw) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			continue
+		}
+		longitude, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			continue
+		}
+		latitude, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			continue
+		}
+		coordinates = append(coordinates, Coordinate{Longitude: longitude, Latitude: latitude})
+	}
+	return coordinates
+}
+
+// placemarkToFeature converts a decoded Placemark into a Feature, picking
+// whichever geometry it carries. Placemarks without a recognised geometry
+// are skipped.
+func placemarkToFeature(placemark kmlPlacemark) *Feature {
+	switch {
+	case placemark.Point != nil:
+		return &Feature{
+			Name:        placemark.Name,
+			Description: placemark.Description,
+			Geometry:    GeometryPoint,
+			Coordinates: parseCoordinates(placemark.Point.Coordinates),
+		}
+	case placemark.LineString != nil:
+		return &Feature{
+			Name:        placemark.Name,
+			Description: placemark.Description,
+			Geometry:    GeometryLineString,
+			Coordinates: parseCoordinates(placemark.LineString.Coordinates),
+		}
+	case placemark.Polygon != nil:
+		return &Feature{
+			Name:        placemark.Name,
+			Description: placemark.Description,
+			Geometry:    GeometryPolygon,
+			Coordinates: parseCoordinates(placemark.Polygon.OuterBoundaryIs.LinearRing.Coordinates),
+		}
+	default:
+		return nil
+	}
+}
+
+func decodeXmlToFeatures(src *zip.File, sourcePath string) ([]*Feature, error) {
+	file, err := src.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var doc kmlFile
+	if err := xml.NewDecoder(file).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	placemarks := collectPlacemarks(doc.Document.Folders, doc.Document.Placemarks)
+
+	features := make([]*Feature, 0, len(placemarks))
+	for _, placemark := range placemarks {
+		feature := placemarkToFeature(placemark)
+		if feature == nil {
+			continue
+		}
+		feature.SourcePath = sourcePath
+		features = append(features, feature)
+	}
+
+	return features, nil
+}
+
+func unzip(src string) ([]*Feature, error) {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var features []*Feature
+
+	for _, file := range r.File {
+		if strings.HasSuffix(file.Name, ".kml") {
+			fileFeatures, err := decodeXmlToFeatures(file, src)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			features = append(features, fileFeatures...)
+		}
+	}
+
+	return features, nil
+}
@@ -1,223 +1,192 @@
 package main
 
 import (
-	"archive/zip"
-	"database/sql"
-	"errors"
+	"context"
+	"flag"
 	"fmt"
-	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
 	"runtime"
-	"strconv"
-	"strings"
-
-	"github.com/clbanning/mxj/v2"
-	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
-)
-
-type PointData struct {
-	Coordinates string
-	Description string
-	Name        string
-}
-
-const (
-	coordinatesPath = "kml.Document.Placemark.Point.coordinates"
-	descriptionPath = "kml.Document.Placemark.description"
-	namePath        = "kml.Document.Placemark.name"
+	"sync"
 )
 
 func main() {
-	var arg string
-
-	if len(os.Args) < 2 {
-		arg = "."
-	} else {
-		arg = os.Args[1]
-	}
-
-	paths, err := crawlFileSystem(arg)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	var (
-		numCpus                = runtime.NumCPU()
-		numPathsToProcess      = len(paths)
-		pathsToProcess         = make(chan string, numPathsToProcess)
-		processedPaths         = make(chan *PointData, numPathsToProcess)
-		dataFromProcessedPaths = make([]*PointData, numPathsToProcess)
-		worker                 int
+		format            = flag.String("format", "sqlite", "formato de saída: sqlite, geopackage ou geojson")
+		output            = flag.String("output", "", "caminho do arquivo de saída (gerado automaticamente se vazio)")
+		geonamesPath      = flag.String("geonames", "", "TSV de cidades do GeoNames para anotar cada feature com país/admin1/cidade mais próxima")
+		mmdbPath          = flag.String("mmdb", "", "banco GeoLite2-City .mmdb (alvo de --geolite-autoupdate; não reverse-geocodifica por coordenadas, ver --geonames)")
+		geoliteAutoupdate = flag.Bool("geolite-autoupdate", false, "baixa uma versão mais nova de --mmdb a partir de --geolite-index-url antes de rodar")
+		geoliteIndexURL   = flag.String("geolite-index-url", "", "URL que lista os GeoLite2-City_YYYYMMDD.mmdb disponíveis para --geolite-autoupdate")
 	)
+	flag.Parse()
 
-	for worker = 1; worker <= numCpus; worker++ {
-		go processPaths(worker, pathsToProcess, processedPaths)
+	arg := "."
+	if flag.NArg() > 0 {
+		arg = flag.Arg(0)
 	}
 
-	for j := 0; j < numPathsToProcess; j++ {
-		pathsToProcess <- paths[j]
-	}
-	close(pathsToProcess)
-
-	for a := 0; a < numPathsToProcess; a++ {
-		dataFromProcessedPaths[a] = <-processedPaths
+	if *geoliteAutoupdate {
+		if *mmdbPath == "" || *geoliteIndexURL == "" {
+			log.Fatal("--geolite-autoupdate requer --mmdb e --geolite-index-url")
+		}
+		if err := updateGeoLite(*mmdbPath, *geoliteIndexURL); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	fmt.Println(dataFromProcessedPaths)
-
-	databaseFilename := uuid.NewString() + ".db"
-
-	file, err := os.Create(databaseFilename)
+	enricher, err := newEnricher(*geonamesPath, *mmdbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	file.Close()
 
-	db, err := sql.Open("sqlite3", databaseFilename)
+	paths, err := crawlFileSystem(arg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
-	err = createTableInSQLite(db)
+	w, err := newWriter(*format, *output)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, pd := range dataFromProcessedPaths {
-		err = pointDataToSQLite(pd, db)
-		if err != nil {
-			log.Fatal(err)
-		}
+	runErr := run(context.Background(), w, enricher, paths)
+	if closeErr := w.Close(); closeErr != nil && runErr == nil {
+		runErr = closeErr
 	}
-}
-
-func createTableInSQLite(db *sql.DB) error {
-	createTableSql := `create table pontos(
-		id text not null primary key,
-		nome text,
-		descricao text,
-		latitude real,
-		longitude real
-	);`
-
-	statement, err := db.Prepare(createTableSql)
-	if err != nil {
-		return err
+	if runErr != nil {
+		log.Fatal(runErr)
 	}
-	_, err = statement.Exec()
-	return err
 }
 
-func pointDataToSQLite(pd *PointData, db *sql.DB) error {
-	if pd == nil {
-		return errors.New("dados do ponto vieram nulos")
-	}
-
-	insertPoint := `
-		insert into pontos(id, nome, descricao, latitude, longitude)
-		values (?, ?, ?, ?, ?)
-	`
-	statement, err := db.Prepare(insertPoint)
-	if err != nil {
-		return err
+// newEnricher builds the GeoNames Enricher when --geonames is given (taking
+// precedence over --mmdb). With only --mmdb it returns newMMDBEnricher's
+// result, which is always a nil Enricher; with neither it returns nil.
+func newEnricher(geonamesPath, mmdbPath string) (Enricher, error) {
+	switch {
+	case geonamesPath != "":
+		return newGeoNamesEnricher(geonamesPath)
+	case mmdbPath != "":
+		return newMMDBEnricher(mmdbPath)
+	default:
+		return nil, nil
 	}
-	u, _ := uuid.NewRandom()
-
-	d := strings.Split(pd.Coordinates, ",")
-
-	longitude, _ := strconv.ParseFloat(d[0], 64)
-	latitude, _ := strconv.ParseFloat(d[1], 64)
-
-	_, err = statement.Exec(u.String(), pd.Name, pd.Description, longitude, latitude)
-	return err
 }
 
-func crawlFileSystem(initialPath string) ([]string, error) {
-	var paths []string
-	err := filepath.Walk(
-		initialPath,
-		func(pathSlice *[]string) filepath.WalkFunc {
-			return func(path string, info fs.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				if strings.HasSuffix(path, ".kmz") {
-					*pathSlice = append(*pathSlice, path)
-				}
-
-				return nil
+// run drives the streaming producer/consumer pipeline: a pool of workers
+// unzips and decodes KMZ paths onto a bounded channel, an optional enrichment
+// stage annotates each Feature, a single writer goroutine drains the result,
+// and the first fatal error from any side cancels ctx so every stage unwinds
+// promptly.
+func run(ctx context.Context, w Writer, enricher Enricher, paths []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	numCpus := runtime.NumCPU()
+	pathsToProcess := make(chan string, 2*numCpus)
+	features := make(chan *Feature, 2*numCpus)
+	sources := make(chan *SourceInfo, 2*numCpus)
+	errs := make(chan error)
+
+	var workers sync.WaitGroup
+	for worker := 1; worker <= numCpus; worker++ {
+		workers.Add(1)
+		go func(id int) {
+			defer workers.Done()
+			processPaths(ctx, id, pathsToProcess, features, sources, errs)
+		}(worker)
+	}
+
+	go func() {
+		defer close(pathsToProcess)
+		for _, path := range paths {
+			select {
+			case pathsToProcess <- path:
+			case <-ctx.Done():
+				return
 			}
-		}(&paths),
-	)
-	return paths, err
-}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(features)
+		close(sources)
+		close(errs)
+	}()
+
+	// firstErr is the fatal error (if any) that aborted the batch; a worker
+	// hitting one cancels ctx so the writer and remaining workers unwind too.
+	var firstErr error
+	errsDrained := make(chan struct{})
+	go func() {
+		defer close(errsDrained)
+		for err := range errs {
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}
+	}()
 
-func processPaths(id int, pathToProcess <-chan string, processedPaths chan<- *PointData) {
-	for j := range pathToProcess {
-		fmt.Println("worker", id, "processando caminho", j)
-		result, _ := unzip(j)
-		fmt.Println("worker", id, "processou caminho", j)
-		processedPaths <- result
-	}
-}
+	enrichedFeatures := enrichStage(ctx, enricher, features)
 
-func decodeXmlToMap(src *zip.File) (*PointData, error) {
-	file, err := src.Open()
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- writeAll(ctx, w, enrichedFeatures, sources)
+	}()
 
-	xmlTree, err := mxj.NewMapXmlReader(file)
-	if err != nil {
-		return nil, err
-	}
+	writerErr := <-writerDone
+	cancel()
+	<-errsDrained
 
-	coordinates, err := xmlTree.ValueForPath(coordinatesPath)
-	if err != nil {
-		return nil, err
+	if firstErr != nil {
+		return firstErr
 	}
-	description, err := xmlTree.ValueForPath(descriptionPath)
-	if err != nil {
-		return nil, err
-	}
-	name, err := xmlTree.ValueForPath(namePath)
-	if err != nil {
-		return nil, err
-	}
-
-	return &PointData{
-		Coordinates: coordinates.(string),
-		Description: description.(string),
-		Name:        name.(string),
-	}, nil
+	return writerErr
 }
 
-func unzip(src string) (*PointData, error) {
-	r, err := zip.OpenReader(src)
-	if err != nil {
-		return nil, err
-	}
-	defer r.Close()
+// processPaths hashes, unzips and decodes each KMZ path it receives,
+// streaming its SourceInfo and Features onto the respective channels. A
+// fatal error (the KMZ can't be stat'd, hashed or opened) is reported on
+// errs and aborts the whole batch via ctx; a bad individual KML entry is
+// logged and skipped instead.
+func processPaths(ctx context.Context, id int, pathToProcess <-chan string, processedPaths chan<- *Feature, processedSources chan<- *SourceInfo, errs chan<- error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path, ok := <-pathToProcess:
+			if !ok {
+				return
+			}
 
-	var kml PointData
+			log.Printf("worker %d processando caminho %s", id, path)
 
-	for _, file := range r.File {
-		if strings.HasSuffix(file.Name, ".kml") {
-			pointData, err := decodeXmlToMap(file)
+			source, err := computeSourceInfo(path)
 			if err != nil {
-				log.Println(err)
-				break
+				errs <- fmt.Errorf("worker %d: %s: %w", id, path, err)
+				return
+			}
+			select {
+			case processedSources <- source:
+			case <-ctx.Done():
+				return
+			}
+
+			features, err := unzip(path)
+			if err != nil {
+				errs <- fmt.Errorf("worker %d: %s: %w", id, path, err)
+				return
+			}
+			log.Printf("worker %d processou caminho %s", id, path)
+
+			for _, feature := range features {
+				select {
+				case processedPaths <- feature:
+				case <-ctx.Done():
+					return
+				}
 			}
-			kml = *pointData
-			break
 		}
 	}
-
-	return &kml, nil
 }
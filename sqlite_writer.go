@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx, so insert helpers
+// don't care whether they're running inside a transaction.
+type sqlExecutor interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// sqliteWriter writes Features into a plain SQLite "pontos" table, committing
+// every batchSize rows so memory stays flat regardless of input size.
+type sqliteWriter struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	pending int
+}
+
+func newSQLiteWriter(path string) (*sqliteWriter, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createTableInSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteWriter{db: db, tx: tx}, nil
+}
+
+func createTableInSQLite(db *sql.DB) error {
+	statements := []string{
+		`create table if not exists pontos(
+			id text not null primary key,
+			nome text,
+			descricao text,
+			tipo_geometria text,
+			latitude real,
+			longitude real,
+			coordenadas text,
+			pais text,
+			admin1 text,
+			cidade_proxima text
+		);`,
+		`create table if not exists sources(
+			path text not null primary key,
+			sha1 text not null,
+			mtime integer not null
+		);`,
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *sqliteWriter) WriteFeature(feature *Feature) error {
+	if err := featureToSQLite(feature, w.tx); err != nil {
+		w.rollback()
+		return err
+	}
+
+	w.pending++
+	if w.pending >= batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *sqliteWriter) commit() error {
+	if w.pending == 0 {
+		return nil
+	}
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	w.pending = 0
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	w.tx = tx
+	return nil
+}
+
+// rollback abandons the current transaction after a failed write and opens a
+// fresh one in its place, so pending rows already lost to the rollback don't
+// linger in w.pending and a later Close doesn't try to commit a dead tx.
+func (w *sqliteWriter) rollback() {
+	w.tx.Rollback()
+	w.pending = 0
+
+	if tx, err := w.db.Begin(); err == nil {
+		w.tx = tx
+	}
+}
+
+func (w *sqliteWriter) WriteSource(source *SourceInfo) error {
+	if err := sourceToSQLite(source, w.tx); err != nil {
+		w.rollback()
+		return err
+	}
+
+	w.pending++
+	if w.pending >= batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *sqliteWriter) Close() error {
+	if err := w.commit(); err != nil {
+		w.db.Close()
+		return err
+	}
+	return w.db.Close()
+}
+
+func featureToSQLite(feature *Feature, db sqlExecutor) error {
+	if feature == nil {
+		return errors.New("dados do ponto vieram nulos")
+	}
+
+	insertPoint := `
+		insert into pontos(id, nome, descricao, tipo_geometria, latitude, longitude, coordenadas, pais, admin1, cidade_proxima)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict(id) do update set
+			nome = excluded.nome,
+			descricao = excluded.descricao,
+			tipo_geometria = excluded.tipo_geometria,
+			latitude = excluded.latitude,
+			longitude = excluded.longitude,
+			coordenadas = excluded.coordenadas,
+			pais = excluded.pais,
+			admin1 = excluded.admin1,
+			cidade_proxima = excluded.cidade_proxima
+	`
+	statement, err := db.Prepare(insertPoint)
+	if err != nil {
+		return err
+	}
+
+	var (
+		latitude  sql.NullFloat64
+		longitude sql.NullFloat64
+	)
+	if feature.Geometry == GeometryPoint && len(feature.Coordinates) == 1 {
+		latitude = sql.NullFloat64{Float64: feature.Coordinates[0].Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: feature.Coordinates[0].Longitude, Valid: true}
+	}
+
+	_, err = statement.Exec(
+		featureID(feature),
+		feature.Name,
+		feature.Description,
+		string(feature.Geometry),
+		latitude,
+		longitude,
+		serializeCoordinates(feature.Coordinates),
+		feature.CountryCode,
+		feature.Admin1,
+		feature.NearestCity,
+	)
+	return err
+}
+
+// sourceToSQLite records (or refreshes) a KMZ's hash and mtime, so the next
+// run can tell whether it changed without re-reading every feature.
+func sourceToSQLite(source *SourceInfo, db sqlExecutor) error {
+	insertSource := `
+		insert into sources(path, sha1, mtime)
+		values (?, ?, ?)
+		on conflict(path) do update set
+			sha1 = excluded.sha1,
+			mtime = excluded.mtime
+	`
+	statement, err := db.Prepare(insertSource)
+	if err != nil {
+		return err
+	}
+
+	_, err = statement.Exec(source.Path, source.SHA1, source.ModTime.Unix())
+	return err
+}
+
+// serializeCoordinates encodes coordinates as "lon,lat lon,lat ..." so
+// LineString and Polygon geometries survive the round trip into a single
+// text column, the same shape KML itself uses for <coordinates>.
+func serializeCoordinates(coordinates []Coordinate) string {
+	parts := make([]string, len(coordinates))
+	for i, c := range coordinates {
+		parts[i] = fmt.Sprintf("%g,%g", c.Longitude, c.Latitude)
+	}
+	return strings.Join(parts, " ")
+}
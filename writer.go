@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// batchSize is how many rows a transactional Writer accumulates per commit.
+const batchSize = 500
+
+// Writer persists decoded Features, and the KMZ sources they came from, to
+// whatever output backend --format selects. Implementations that support
+// transactions are expected to batch internally (see batchSize) so memory
+// stays flat regardless of input size.
+type Writer interface {
+	WriteFeature(feature *Feature) error
+	WriteSource(source *SourceInfo) error
+	Close() error
+}
+
+// newWriter builds the Writer for the requested --format, defaulting to
+// plain SQLite when format is empty.
+func newWriter(format, output string) (Writer, error) {
+	switch format {
+	case "", "sqlite":
+		return newSQLiteWriter(outputPathOrDefault(output, "db"))
+	case "geopackage":
+		return newGeoPackageWriter(outputPathOrDefault(output, "gpkg"))
+	case "geojson":
+		return newGeoJSONWriter(output)
+	default:
+		return nil, fmt.Errorf("formato de saída desconhecido: %s", format)
+	}
+}
+
+// outputPathOrDefault returns output unchanged when set, otherwise a fresh
+// randomly named file with the given extension.
+func outputPathOrDefault(output, extension string) string {
+	if output != "" {
+		return output
+	}
+	return uuid.NewString() + "." + extension
+}
+
+// writeAll drains both features and sources into w until each channel
+// closes, or returns early if ctx is cancelled. A nil channel blocks forever
+// in a select, which is what lets one side finish before the other without
+// spinning.
+func writeAll(ctx context.Context, w Writer, features <-chan *Feature, sources <-chan *SourceInfo) error {
+	for features != nil || sources != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case feature, ok := <-features:
+			if !ok {
+				features = nil
+				continue
+			}
+			if err := w.WriteFeature(feature); err != nil {
+				return err
+			}
+		case source, ok := <-sources:
+			if !ok {
+				sources = nil
+				continue
+			}
+			if err := w.WriteSource(source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}